@@ -2,19 +2,165 @@ package formatter
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"os"
+	"regexp"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/mgutz/ansi"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/term"
 )
 
+// OutputFormat selects the rendering backend used by Formatter.Format.
+type OutputFormat int
+
+const (
+	// FormatText renders the classic bracketed "[time] [LEVL] ..." text.
+	FormatText OutputFormat = iota
+	// FormatJSON renders one JSON object per line.
+	FormatJSON
+	// FormatLogfmt renders "key=value" pairs, space separated.
+	FormatLogfmt
+)
+
+// fieldKey identifies one of the built-in fields this Formatter writes
+// so its rendered name can be customized via FieldMap.
+type fieldKey string
+
+// Built-in field keys usable with FieldMap.
+const (
+	FieldKeyTime  fieldKey = "time"
+	FieldKeyLevel fieldKey = "level"
+	FieldKeyMsg   fieldKey = "msg"
+	FieldKeyFunc  fieldKey = "func"
+	FieldKeyFile  fieldKey = "file"
+)
+
+// FieldMap allows users to customize the rendered name of the built-in
+// fields (time, level, msg, func, file). Any fieldKey not present in
+// the map keeps its default name.
+type FieldMap map[fieldKey]string
+
+func (f FieldMap) resolve(key fieldKey) string {
+	if k, ok := f[key]; ok {
+		return k
+	}
+	return string(key)
+}
+
+// ColorScheme customizes the ANSI style used for each piece of a
+// colored text log line. Styles are strings in the mgutz/ansi grammar,
+// e.g. "red+b", "cyan+h" or "226" (256-color), plus a "#rrggbb" form
+// handled directly by this package for 24-bit truecolor, since
+// mgutz/ansi itself has no hex support.
+type ColorScheme struct {
+	InfoLevelStyle  string
+	WarnLevelStyle  string
+	ErrorLevelStyle string
+	DebugLevelStyle string
+	TimestampStyle  string
+	FieldKeyStyle   string
+	FieldValueStyle string
+	CallerStyle     string
+}
+
+func defaultColorScheme() *ColorScheme {
+	return &ColorScheme{
+		InfoLevelStyle:  "cyan",
+		WarnLevelStyle:  "yellow",
+		ErrorLevelStyle: "red",
+		DebugLevelStyle: "black+h",
+		TimestampStyle:  "white",
+		FieldKeyStyle:   "white",
+		FieldValueStyle: "white",
+		CallerStyle:     "white",
+	}
+}
+
+// compiledColorScheme holds a ColorScheme's styles compiled into
+// reusable coloring closures, so the mgutz/ansi grammar is only parsed
+// once per Formatter.
+type compiledColorScheme struct {
+	InfoLevelColor  func(string) string
+	WarnLevelColor  func(string) string
+	ErrorLevelColor func(string) string
+	DebugLevelColor func(string) string
+	TimestampColor  func(string) string
+	FieldKeyColor   func(string) string
+	FieldValueColor func(string) string
+	CallerColor     func(string) string
+}
+
+func compileColorScheme(s *ColorScheme) *compiledColorScheme {
+	return &compiledColorScheme{
+		InfoLevelColor:  colorFunc(s.InfoLevelStyle),
+		WarnLevelColor:  colorFunc(s.WarnLevelStyle),
+		ErrorLevelColor: colorFunc(s.ErrorLevelStyle),
+		DebugLevelColor: colorFunc(s.DebugLevelStyle),
+		TimestampColor:  colorFunc(s.TimestampStyle),
+		FieldKeyColor:   colorFunc(s.FieldKeyStyle),
+		FieldValueColor: colorFunc(s.FieldValueStyle),
+		CallerColor:     colorFunc(s.CallerStyle),
+	}
+}
+
+// hexColorPattern matches a "#rrggbb" truecolor style string.
+var hexColorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{6})$`)
+
+// colorFunc compiles a style string into a coloring closure. "#rrggbb"
+// is handled directly as a 24-bit ANSI escape, since mgutz/ansi has no
+// truecolor support; everything else is delegated to ansi.ColorFunc.
+func colorFunc(style string) func(string) string {
+	if m := hexColorPattern.FindStringSubmatch(style); m != nil {
+		r, _ := strconv.ParseUint(m[1][0:2], 16, 8)
+		g, _ := strconv.ParseUint(m[1][2:4], 16, 8)
+		b, _ := strconv.ParseUint(m[1][4:6], 16, 8)
+		prefix := fmt.Sprintf("\x1b[38;2;%d;%d;%dm", r, g, b)
+		return func(text string) string {
+			return prefix + text + "\x1b[0m"
+		}
+	}
+	return ansi.ColorFunc(style)
+}
+
+func (c *compiledColorScheme) levelColor(level logrus.Level) func(string) string {
+	switch level {
+	case logrus.DebugLevel, logrus.TraceLevel:
+		return c.DebugLevelColor
+	case logrus.WarnLevel:
+		return c.WarnLevelColor
+	case logrus.ErrorLevel, logrus.FatalLevel, logrus.PanicLevel:
+		return c.ErrorLevelColor
+	default:
+		return c.InfoLevelColor
+	}
+}
+
 // Formatter - logrus formatter, implements logrus.Formatter
 type Formatter struct {
+	// OutputFormat - default: FormatText
+	OutputFormat OutputFormat
+
 	// FieldsOrder - default: fields sorted alphabetically
 	FieldsOrder []string
 
+	// FieldMap allows customizing the names of the built-in fields
+	// (time/level/msg/func/file). If entry.Data contains a key that
+	// clashes with one of these rendered names, it is written out as
+	// "fields.<name>" instead, mirroring logrus's own clash handling.
+	// Only OutputFormat FormatJSON and FormatLogfmt render these names
+	// anywhere; FormatText's "[time] [LEVEL] msg" prefix is purely
+	// positional, so FieldMap has no visible effect there beyond clash
+	// prefixing.
+	FieldMap FieldMap
+
 	// TimestampFormat - default: time.TimeOnly = "15:04:05"
 	TimestampFormat string
 
@@ -24,147 +170,459 @@ type Formatter struct {
 	// NoColors - disable colors
 	NoColors bool
 
+	// ForceColors - force colored output even when the destination is
+	// not a terminal
+	ForceColors bool
+
+	// EnvironmentOverrideColors - honor the CLICOLOR/CLICOLOR_FORCE
+	// environment variables (see https://bixense.com/clicolors/):
+	// CLICOLOR_FORCE!=0 forces colors on, CLICOLOR_FORCE=0 or (with
+	// CLICOLOR_FORCE unset) CLICOLOR=0 forces colors off, otherwise
+	// colors follow ForceColors / terminal auto-detection. NoColors
+	// still disables colors in every case
+	EnvironmentOverrideColors bool
+
 	// DisableTrimMessages - disable trim whitespaces on messages
 	DisableTrimMessages bool
+
+	// ForceQuote - wrap every field value in double quotes
+	ForceQuote bool
+
+	// DisableQuote - never wrap field values in double quotes, even if
+	// they contain characters that would otherwise need escaping
+	DisableQuote bool
+
+	// QuoteEmptyFields - wrap empty field values in double quotes
+	QuoteEmptyFields bool
+
+	// ColorScheme - default: defaultColorScheme()
+	ColorScheme *ColorScheme
+
+	// SortingFunc - default: sort.Strings (alphabetical). Called with
+	// the field names to be written so callers can apply a custom
+	// ordering (e.g. trace_id before span_id) instead of enumerating
+	// every key in FieldsOrder. Only affects FormatText and
+	// FormatLogfmt: FormatJSON is encoded with encoding/json, which
+	// always emits object keys in alphabetical order regardless of
+	// SortingFunc/DisableSorting.
+	SortingFunc func([]string)
+
+	// DisableSorting - skip the default alphabetical sort and write
+	// fields (and the unordered tail in writeOrderedFields) in map
+	// iteration order. A non-nil SortingFunc is still applied when
+	// DisableSorting is set. Like SortingFunc, this has no effect on
+	// FormatJSON.
+	DisableSorting bool
+
+	// CallerPrettyfier - rewrites the function/file reported for the
+	// caller, e.g. to strip the module root or shorten paths. In
+	// FormatText, a "" return value omits that component from the
+	// caller suffix; FormatJSON and FormatLogfmt still write its key,
+	// with an empty value
+	CallerPrettyfier func(*runtime.Frame) (function string, file string)
+
+	// DisableLevelTruncation - show the full level name (e.g. "WARNING",
+	// "PANIC") instead of the fixed 4-character level[:4] slice, which
+	// produces inconsistent abbreviations like "WARN" and "PANI"
+	DisableLevelTruncation bool
+
+	terminalOnce sync.Once
+	isTerminal   bool
+
+	colorSchemeOnce sync.Once
+	colorScheme     *compiledColorScheme
+}
+
+func (f *Formatter) getColorScheme() *compiledColorScheme {
+	f.colorSchemeOnce.Do(func() {
+		scheme := f.ColorScheme
+		if scheme == nil {
+			scheme = defaultColorScheme()
+		}
+		f.colorScheme = compileColorScheme(scheme)
+	})
+	return f.colorScheme
 }
 
 // Format an log entry
 func (f *Formatter) Format(entry *logrus.Entry) ([]byte, error) {
-	levelColor := getColorByLevel(entry.Level)
+	switch f.OutputFormat {
+	case FormatJSON:
+		return f.formatJSON(entry)
+	case FormatLogfmt:
+		return f.formatLogfmt(entry)
+	default:
+		return f.formatText(entry)
+	}
+}
+
+// prepareData copies entry.Data and resolves any clash with the
+// built-in field names, so every rendering backend can share the same
+// clash-handling behavior.
+func (f *Formatter) prepareData(entry *logrus.Entry) logrus.Fields {
+	data := make(logrus.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		data[k] = v
+	}
+	prefixFieldClashes(data, f.FieldMap, entry.HasCaller())
+	return data
+}
 
+func (f *Formatter) formatText(entry *logrus.Entry) ([]byte, error) {
 	timestampFormat := f.TimestampFormat
 	if timestampFormat == "" {
 		timestampFormat = time.TimeOnly
 	}
 
+	var scheme *compiledColorScheme
+	if f.isColored(entry) {
+		scheme = f.getColorScheme()
+	}
+
+	data := f.prepareData(entry)
+
 	// output buffer
 	b := &bytes.Buffer{}
 
 	// write time
+	timestamp := entry.Time.Format(timestampFormat)
+	if scheme != nil {
+		timestamp = scheme.TimestampColor(timestamp)
+	}
 	b.WriteString("[")
-	b.WriteString(entry.Time.Format(timestampFormat))
+	b.WriteString(timestamp)
 	b.WriteString("]")
 
 	// write level
-	var level = strings.ToUpper(entry.Level.String())
-
-	if !f.NoColors {
-		fmt.Fprintf(b, "\x1b[%dm", levelColor)
+	level := strings.ToUpper(entry.Level.String())
+	if !f.DisableLevelTruncation {
+		level = level[:4]
+	}
+	if scheme != nil {
+		level = scheme.levelColor(entry.Level)(level)
 	}
 
 	b.WriteString(" [")
-	b.WriteString(level[:4])
+	b.WriteString(level)
 	b.WriteString("]")
 	b.WriteString(" ")
 
 	// write fields
 	if f.FieldsOrder == nil {
-		f.writeFields(b, entry)
+		f.writeFields(b, data, scheme)
 	} else {
-		f.writeOrderedFields(b, entry)
-	}
-	if !f.NoColors {
-		fmt.Fprintf(b, "\x1b[%dm", colorNone)
+		f.writeOrderedFields(b, data, scheme)
 	}
 
 	// write message
-	if f.DisableTrimMessages {
-		b.WriteString(entry.Message)
-	} else {
-		b.WriteString(strings.TrimSpace(entry.Message))
+	message := entry.Message
+	if !f.DisableTrimMessages {
+		message = strings.TrimSpace(message)
 	}
+	if messageNeedsQuoting(message) {
+		message = fmt.Sprintf("%q", message)
+	}
+	b.WriteString(message)
 
-	f.writeCaller(b, entry)
+	f.writeCaller(b, entry, scheme)
 	b.WriteByte('\n')
 
 	return b.Bytes(), nil
 }
 
-func (f *Formatter) writeCaller(b *bytes.Buffer, entry *logrus.Entry) {
+func (f *Formatter) formatJSON(entry *logrus.Entry) ([]byte, error) {
+	timestampFormat := f.TimestampFormat
+	if timestampFormat == "" {
+		timestampFormat = time.TimeOnly
+	}
+
+	data := f.prepareData(entry)
+	for k, v := range data {
+		if err, ok := v.(error); ok {
+			data[k] = err.Error()
+		}
+	}
+
+	data[f.FieldMap.resolve(FieldKeyTime)] = entry.Time.Format(timestampFormat)
+	data[f.FieldMap.resolve(FieldKeyLevel)] = strings.ToUpper(entry.Level.String())
+	data[f.FieldMap.resolve(FieldKeyMsg)] = entry.Message
+
 	if entry.HasCaller() {
-		fmt.Fprintf(
-			b,
-			" (%s:%d %s)",
-			entry.Caller.File,
-			entry.Caller.Line,
-			entry.Caller.Function,
-		)
+		function, file := f.resolveCaller(entry)
+		data[f.FieldMap.resolve(FieldKeyFunc)] = function
+		data[f.FieldMap.resolve(FieldKeyFile)] = file
 	}
+
+	b := &bytes.Buffer{}
+	encoder := json.NewEncoder(b)
+	if err := encoder.Encode(data); err != nil {
+		return nil, fmt.Errorf("failed to marshal fields to JSON: %w", err)
+	}
+
+	return b.Bytes(), nil
 }
 
-func (f *Formatter) writeFields(b *bytes.Buffer, entry *logrus.Entry) {
-	if len(entry.Data) != 0 {
-		fields := make([]string, 0, len(entry.Data))
-		for field := range entry.Data {
-			fields = append(fields, field)
+func (f *Formatter) formatLogfmt(entry *logrus.Entry) ([]byte, error) {
+	timestampFormat := f.TimestampFormat
+	if timestampFormat == "" {
+		timestampFormat = time.TimeOnly
+	}
+
+	data := f.prepareData(entry)
+
+	b := &bytes.Buffer{}
+	f.writeLogfmtField(b, f.FieldMap.resolve(FieldKeyTime), entry.Time.Format(timestampFormat))
+	f.writeLogfmtField(b, f.FieldMap.resolve(FieldKeyLevel), strings.ToUpper(entry.Level.String()))
+	f.writeLogfmtField(b, f.FieldMap.resolve(FieldKeyMsg), entry.Message)
+
+	fields := make([]string, 0, len(data))
+	for field := range data {
+		fields = append(fields, field)
+	}
+	f.sortFields(fields)
+	for _, field := range fields {
+		f.writeLogfmtField(b, field, fmt.Sprintf("%v", data[field]))
+	}
+
+	if entry.HasCaller() {
+		function, file := f.resolveCaller(entry)
+		f.writeLogfmtField(b, f.FieldMap.resolve(FieldKeyFunc), function)
+		f.writeLogfmtField(b, f.FieldMap.resolve(FieldKeyFile), file)
+	}
+
+	b.Truncate(b.Len() - 1) // trim the trailing space
+	b.WriteByte('\n')
+
+	return b.Bytes(), nil
+}
+
+func (f *Formatter) writeLogfmtField(b *bytes.Buffer, key, value string) {
+	if f.needsQuoting(value) {
+		value = fmt.Sprintf("%q", value)
+	}
+	fmt.Fprintf(b, "%s=%s ", key, value)
+}
+
+// messageNeedsQuoting reports whether msg contains newlines or other
+// control characters that would otherwise break a single-line text log.
+func messageNeedsQuoting(msg string) bool {
+	for _, ch := range msg {
+		if ch == '\n' || ch == '\r' || (ch < 0x20 && ch != '\t') {
+			return true
+		}
+	}
+	return false
+}
+
+// isColored reports whether this entry should be rendered with ANSI
+// color codes, following the bixense CLICOLOR convention when
+// EnvironmentOverrideColors is set, and falling back to ForceColors /
+// TTY auto-detection of entry.Logger.Out otherwise.
+func (f *Formatter) isColored(entry *logrus.Entry) bool {
+	isColored := f.ForceColors || f.checkIfTerminal(entry)
+
+	if f.EnvironmentOverrideColors {
+		switch force, ok := os.LookupEnv("CLICOLOR_FORCE"); {
+		case ok && force != "0":
+			isColored = true
+		case ok && force == "0":
+			isColored = false
+		case os.Getenv("CLICOLOR") == "0":
+			isColored = false
+		}
+	}
+
+	return isColored && !f.NoColors
+}
+
+// checkIfTerminal lazily detects, once per Formatter, whether the
+// destination logrus writes to is a terminal.
+func (f *Formatter) checkIfTerminal(entry *logrus.Entry) bool {
+	f.terminalOnce.Do(func() {
+		if entry.Logger == nil {
+			return
+		}
+		type fdWriter interface {
+			Fd() uintptr
+		}
+		if w, ok := entry.Logger.Out.(fdWriter); ok {
+			f.isTerminal = term.IsTerminal(int(w.Fd()))
 		}
+	})
+	return f.isTerminal
+}
+
+// resolveCaller returns the function and file:line to report for
+// entry's caller, rewritten through CallerPrettyfier if set.
+func (f *Formatter) resolveCaller(entry *logrus.Entry) (function, file string) {
+	function = entry.Caller.Function
+	file = fmt.Sprintf("%s:%d", entry.Caller.File, entry.Caller.Line)
+	if f.CallerPrettyfier != nil {
+		function, file = f.CallerPrettyfier(entry.Caller)
+	}
+	return
+}
+
+func (f *Formatter) writeCaller(b *bytes.Buffer, entry *logrus.Entry, scheme *compiledColorScheme) {
+	if !entry.HasCaller() {
+		return
+	}
+
+	function, file := f.resolveCaller(entry)
+	parts := make([]string, 0, 2)
+	if file != "" {
+		parts = append(parts, file)
+	}
+	if function != "" {
+		parts = append(parts, function)
+	}
+	if len(parts) == 0 {
+		return
+	}
 
+	caller := strings.Join(parts, " ")
+	if scheme != nil {
+		caller = scheme.CallerColor(caller)
+	}
+	fmt.Fprintf(b, " (%s)", caller)
+}
+
+// sortFields orders fields in place using SortingFunc if set, falling
+// back to alphabetical sort.Strings unless DisableSorting is set.
+func (f *Formatter) sortFields(fields []string) {
+	switch {
+	case f.SortingFunc != nil:
+		f.SortingFunc(fields)
+	case f.DisableSorting:
+		// keep map iteration order
+	default:
 		sort.Strings(fields)
+	}
+}
+
+func (f *Formatter) writeFields(b *bytes.Buffer, data logrus.Fields, scheme *compiledColorScheme) {
+	if len(data) != 0 {
+		fields := make([]string, 0, len(data))
+		for field := range data {
+			fields = append(fields, field)
+		}
+
+		f.sortFields(fields)
 
 		for _, field := range fields {
-			f.writeField(b, entry, field)
+			f.writeField(b, data, field, scheme)
 		}
 	}
 }
 
-func (f *Formatter) writeOrderedFields(b *bytes.Buffer, entry *logrus.Entry) {
-	length := len(entry.Data)
+func (f *Formatter) writeOrderedFields(b *bytes.Buffer, data logrus.Fields, scheme *compiledColorScheme) {
+	length := len(data)
 	foundFieldsMap := map[string]bool{}
 	for _, field := range f.FieldsOrder {
-		if _, ok := entry.Data[field]; ok {
+		if _, ok := data[field]; ok {
 			foundFieldsMap[field] = true
 			length--
-			f.writeField(b, entry, field)
+			f.writeField(b, data, field, scheme)
 		}
 	}
 
 	if length > 0 {
 		notFoundFields := make([]string, 0, length)
-		for field := range entry.Data {
+		for field := range data {
 			if foundFieldsMap[field] == false {
 				notFoundFields = append(notFoundFields, field)
 			}
 		}
 
-		sort.Strings(notFoundFields)
+		f.sortFields(notFoundFields)
 
 		for _, field := range notFoundFields {
-			f.writeField(b, entry, field)
+			f.writeField(b, data, field, scheme)
 		}
 	}
 }
 
-func (f *Formatter) writeField(b *bytes.Buffer, entry *logrus.Entry, field string) {
+func (f *Formatter) writeField(b *bytes.Buffer, data logrus.Fields, field string, scheme *compiledColorScheme) {
+	value := fmt.Sprintf("%v", data[field])
+	if f.needsQuoting(value) {
+		value = fmt.Sprintf("%q", value)
+	}
+
+	key := field
+	if scheme != nil {
+		key = scheme.FieldKeyColor(key)
+		value = scheme.FieldValueColor(value)
+	}
+
 	if f.HideKeys {
-		fmt.Fprintf(b, "[%v]", entry.Data[field])
+		fmt.Fprintf(b, "[%s]", value)
 	} else {
-		fmt.Fprintf(b, "[%s:%v]", field, entry.Data[field])
+		fmt.Fprintf(b, "[%s:%s]", key, value)
 	}
 
 	b.WriteString(" ")
 }
 
-const (
-	colorNone    = 00
-	colorBlack   = 30
-	colorRed     = 31
-	colorGreen   = 32
-	colorYellow  = 33
-	colorBlue    = 34
-	colorMagenta = 35
-	colorCyan    = 36
-	colorGray    = 37
-)
+// needsQuoting reports whether text should be wrapped in double quotes
+// when rendered as a field value, honoring ForceQuote/DisableQuote/
+// QuoteEmptyFields. It mirrors logrus's TextFormatter: values made up
+// only of alphanumerics and a small set of "safe" punctuation are left
+// bare, everything else (spaces, `=`, quotes, brackets, ANSI escapes,
+// other non-printable runes) is quoted to keep output parseable.
+func (f *Formatter) needsQuoting(text string) bool {
+	if f.ForceQuote {
+		return true
+	}
+	if f.DisableQuote {
+		return false
+	}
+	if f.QuoteEmptyFields && len(text) == 0 {
+		return true
+	}
+	for _, ch := range text {
+		if !((ch >= 'a' && ch <= 'z') ||
+			(ch >= 'A' && ch <= 'Z') ||
+			(ch >= '0' && ch <= '9') ||
+			ch == '-' || ch == '.' || ch == '_' || ch == '/' || ch == '@' || ch == '^' || ch == '+') {
+			return true
+		}
+	}
+	return false
+}
 
-func getColorByLevel(level logrus.Level) int {
-	switch level {
-	case logrus.DebugLevel, logrus.TraceLevel:
-		return colorGray
-	case logrus.WarnLevel:
-		return colorYellow
-	case logrus.ErrorLevel, logrus.FatalLevel, logrus.PanicLevel:
-		return colorRed
-	default:
-		return colorCyan
+// prefixFieldClashes renames any user field in data that collides with
+// one of the built-in field names (as resolved through fieldMap) to
+// "fields.<name>", so the built-in fields written elsewhere in Format
+// stay unambiguous.
+func prefixFieldClashes(data logrus.Fields, fieldMap FieldMap, hasCaller bool) {
+	timeKey := fieldMap.resolve(FieldKeyTime)
+	if t, ok := data[timeKey]; ok {
+		data["fields."+timeKey] = t
+		delete(data, timeKey)
+	}
+
+	msgKey := fieldMap.resolve(FieldKeyMsg)
+	if m, ok := data[msgKey]; ok {
+		data["fields."+msgKey] = m
+		delete(data, msgKey)
+	}
+
+	levelKey := fieldMap.resolve(FieldKeyLevel)
+	if l, ok := data[levelKey]; ok {
+		data["fields."+levelKey] = l
+		delete(data, levelKey)
+	}
+
+	if hasCaller {
+		funcKey := fieldMap.resolve(FieldKeyFunc)
+		if l, ok := data[funcKey]; ok {
+			data["fields."+funcKey] = l
+			delete(data, funcKey)
+		}
+		fileKey := fieldMap.resolve(FieldKeyFile)
+		if l, ok := data[fileKey]; ok {
+			data["fields."+fileKey] = l
+			delete(data, fileKey)
+		}
 	}
 }
@@ -0,0 +1,293 @@
+package formatter
+
+import (
+	"encoding/json"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newEntry(data logrus.Fields, message string) *logrus.Entry {
+	return &logrus.Entry{
+		Logger:  logrus.New(),
+		Data:    data,
+		Time:    time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Level:   logrus.InfoLevel,
+		Message: message,
+	}
+}
+
+// newEntryWithCaller builds an entry whose HasCaller() is true, which
+// requires Logger.ReportCaller set in addition to a non-nil Caller.
+func newEntryWithCaller(data logrus.Fields, message string, caller *runtime.Frame) *logrus.Entry {
+	entry := newEntry(data, message)
+	entry.Logger.ReportCaller = true
+	entry.Caller = caller
+	return entry
+}
+
+func TestFormatText(t *testing.T) {
+	f := &Formatter{}
+	entry := newEntry(logrus.Fields{"user": "alice"}, "hello world")
+
+	out, err := f.Format(entry)
+	if err != nil {
+		t.Fatalf("Format() error = %v", err)
+	}
+
+	want := "[03:04:05] [INFO] [user:alice] hello world\n"
+	if got := string(out); got != want {
+		t.Errorf("Format() = %q, want %q", got, want)
+	}
+}
+
+func TestFormatTextQuotesValuesNeedingIt(t *testing.T) {
+	f := &Formatter{}
+	entry := newEntry(logrus.Fields{"name": "jane doe"}, "hi")
+
+	out, err := f.Format(entry)
+	if err != nil {
+		t.Fatalf("Format() error = %v", err)
+	}
+
+	if !strings.Contains(string(out), `[name:"jane doe"]`) {
+		t.Errorf("Format() = %q, want quoted field value", out)
+	}
+}
+
+func TestFormatJSON(t *testing.T) {
+	f := &Formatter{OutputFormat: FormatJSON}
+	entry := newEntry(logrus.Fields{"user": "alice"}, "hello")
+
+	out, err := f.Format(entry)
+	if err != nil {
+		t.Fatalf("Format() error = %v", err)
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(out, &data); err != nil {
+		t.Fatalf("Format() produced invalid JSON: %v", err)
+	}
+
+	if data["msg"] != "hello" || data["user"] != "alice" || data["level"] != "INFO" {
+		t.Errorf("Format() JSON = %v, want msg/user/level set", data)
+	}
+}
+
+func TestFormatJSONClashPrefixing(t *testing.T) {
+	f := &Formatter{OutputFormat: FormatJSON}
+	entry := newEntry(logrus.Fields{"msg": "user-supplied"}, "actual message")
+
+	out, err := f.Format(entry)
+	if err != nil {
+		t.Fatalf("Format() error = %v", err)
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(out, &data); err != nil {
+		t.Fatalf("Format() produced invalid JSON: %v", err)
+	}
+
+	if data["msg"] != "actual message" || data["fields.msg"] != "user-supplied" {
+		t.Errorf("Format() JSON = %v, want clashing user field prefixed with fields.", data)
+	}
+}
+
+func TestFormatJSONFieldMapRenamesBuiltins(t *testing.T) {
+	f := &Formatter{OutputFormat: FormatJSON, FieldMap: FieldMap{FieldKeyTime: "@timestamp"}}
+	entry := newEntry(nil, "hi")
+
+	out, err := f.Format(entry)
+	if err != nil {
+		t.Fatalf("Format() error = %v", err)
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(out, &data); err != nil {
+		t.Fatalf("Format() produced invalid JSON: %v", err)
+	}
+
+	if _, ok := data["@timestamp"]; !ok {
+		t.Errorf("Format() JSON = %v, want @timestamp key from FieldMap", data)
+	}
+}
+
+func TestFormatLogfmtQuotesWhitespace(t *testing.T) {
+	f := &Formatter{OutputFormat: FormatLogfmt}
+	entry := newEntry(logrus.Fields{"name": "jane doe"}, "hello")
+
+	out, err := f.Format(entry)
+	if err != nil {
+		t.Fatalf("Format() error = %v", err)
+	}
+
+	if !strings.Contains(string(out), `name="jane doe"`) {
+		t.Errorf("Format() = %q, want quoted field value", out)
+	}
+}
+
+func TestSortingFuncHonoredInTextAndLogfmt(t *testing.T) {
+	reverse := func(fields []string) {
+		sort.Sort(sort.Reverse(sort.StringSlice(fields)))
+	}
+	data := logrus.Fields{"aaa": 1, "zzz": 2}
+
+	text, err := (&Formatter{SortingFunc: reverse}).Format(newEntry(data, "hi"))
+	if err != nil {
+		t.Fatalf("Format() error = %v", err)
+	}
+	if !strings.Contains(string(text), "[zzz:2] [aaa:1]") {
+		t.Errorf("text Format() = %q, want zzz before aaa", text)
+	}
+
+	logfmt, err := (&Formatter{OutputFormat: FormatLogfmt, SortingFunc: reverse}).Format(newEntry(data, "hi"))
+	if err != nil {
+		t.Fatalf("Format() error = %v", err)
+	}
+	if !strings.Contains(string(logfmt), "zzz=2 aaa=1") {
+		t.Errorf("logfmt Format() = %q, want zzz before aaa", logfmt)
+	}
+}
+
+// TestSortingFuncHasNoEffectOnJSON documents that SortingFunc/DisableSorting
+// cannot influence FormatJSON output: encoding/json always emits object
+// keys in alphabetical order.
+func TestSortingFuncHasNoEffectOnJSON(t *testing.T) {
+	reverse := func(fields []string) {
+		sort.Sort(sort.Reverse(sort.StringSlice(fields)))
+	}
+	data := logrus.Fields{"aaa": 1, "zzz": 2}
+
+	out, err := (&Formatter{OutputFormat: FormatJSON, SortingFunc: reverse}).Format(newEntry(data, "hi"))
+	if err != nil {
+		t.Fatalf("Format() error = %v", err)
+	}
+	if !strings.Contains(string(out), `"aaa":1,`) || strings.Index(string(out), `"aaa"`) > strings.Index(string(out), `"zzz"`) {
+		t.Errorf("JSON Format() = %s, want aaa before zzz regardless of SortingFunc", out)
+	}
+}
+
+func TestColorFuncTruecolor(t *testing.T) {
+	colorize := colorFunc("#ff8800")
+
+	got := colorize("x")
+	want := "\x1b[38;2;255;136;0mx\x1b[0m"
+	if got != want {
+		t.Errorf("colorFunc(\"#ff8800\")(\"x\") = %q, want %q", got, want)
+	}
+}
+
+func TestIsColoredEnvironmentOverride(t *testing.T) {
+	entry := newEntry(nil, "hi")
+
+	t.Setenv("CLICOLOR_FORCE", "1")
+	f := &Formatter{EnvironmentOverrideColors: true}
+	if !f.isColored(entry) {
+		t.Errorf("isColored() = false, want true when CLICOLOR_FORCE=1")
+	}
+
+	t.Setenv("CLICOLOR_FORCE", "0")
+	f = &Formatter{EnvironmentOverrideColors: true, ForceColors: true}
+	if f.isColored(entry) {
+		t.Errorf("isColored() = true, want false when CLICOLOR_FORCE=0")
+	}
+}
+
+func TestNeedsQuoting(t *testing.T) {
+	tests := []struct {
+		name string
+		f    *Formatter
+		text string
+		want bool
+	}{
+		{"plain", &Formatter{}, "alice", false},
+		{"space", &Formatter{}, "jane doe", true},
+		{"forceQuote", &Formatter{ForceQuote: true}, "alice", true},
+		{"disableQuote", &Formatter{DisableQuote: true}, "jane doe", false},
+		{"emptyDefault", &Formatter{}, "", false},
+		{"emptyQuoted", &Formatter{QuoteEmptyFields: true}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.f.needsQuoting(tt.text); got != tt.want {
+				t.Errorf("needsQuoting(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatTextWithCallerAndPrettyfier(t *testing.T) {
+	caller := &runtime.Frame{
+		Function: "github.com/STARRY-S/simple-logrus-formatter/pkg/formatter.TestFoo",
+		File:     "/home/user/src/simple-logrus-formatter/pkg/formatter/formatter_test.go",
+		Line:     42,
+	}
+	f := &Formatter{
+		DisableLevelTruncation: true,
+		CallerPrettyfier: func(frame *runtime.Frame) (function string, file string) {
+			return "TestFoo", "formatter_test.go:42"
+		},
+	}
+	entry := newEntryWithCaller(logrus.Fields{"user": "alice"}, "hello", caller)
+	entry.Level = logrus.WarnLevel
+
+	out, err := f.Format(entry)
+	if err != nil {
+		t.Fatalf("Format() error = %v", err)
+	}
+
+	want := "[03:04:05] [WARNING] [user:alice] hello (formatter_test.go:42 TestFoo)\n"
+	if got := string(out); got != want {
+		t.Errorf("Format() = %q, want %q", got, want)
+	}
+}
+
+func TestFormatTextCallerPrettyfierSuppressesComponent(t *testing.T) {
+	caller := &runtime.Frame{Function: "pkg.Foo", File: "/src/foo.go", Line: 10}
+	f := &Formatter{
+		CallerPrettyfier: func(frame *runtime.Frame) (function string, file string) {
+			return "", "foo.go:10"
+		},
+	}
+	entry := newEntryWithCaller(nil, "hi", caller)
+
+	out, err := f.Format(entry)
+	if err != nil {
+		t.Fatalf("Format() error = %v", err)
+	}
+
+	if !strings.Contains(string(out), "(foo.go:10)") {
+		t.Errorf("Format() = %q, want only the file component in the caller suffix", out)
+	}
+	if strings.Contains(string(out), "pkg.Foo") {
+		t.Errorf("Format() = %q, want function component suppressed", out)
+	}
+}
+
+func TestFormatJSONWithCallerAndClashPrefixing(t *testing.T) {
+	caller := &runtime.Frame{Function: "pkg.Real", File: "/src/real.go", Line: 7}
+	f := &Formatter{OutputFormat: FormatJSON}
+	data := logrus.Fields{"func": "user-supplied-func", "file": "user-supplied-file"}
+	entry := newEntryWithCaller(data, "hi", caller)
+
+	out, err := f.Format(entry)
+	if err != nil {
+		t.Fatalf("Format() error = %v", err)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatalf("Format() produced invalid JSON: %v", err)
+	}
+
+	if got["func"] != "pkg.Real" || got["file"] != "/src/real.go:7" {
+		t.Errorf("Format() JSON = %v, want builtin func/file set from the caller", got)
+	}
+	if got["fields.func"] != "user-supplied-func" || got["fields.file"] != "user-supplied-file" {
+		t.Errorf("Format() JSON = %v, want clashing user func/file fields prefixed with fields.", got)
+	}
+}